@@ -0,0 +1,61 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// Phase identifies which step of a job's pipeline a failure happened in.
+type Phase string
+
+const (
+	PhaseRead    Phase = "read"
+	PhaseExecute Phase = "execute"
+	PhaseMarshal Phase = "marshal"
+	PhaseWrite   Phase = "write"
+)
+
+// EfemelError is a per-job failure carrying enough context (which file,
+// which phase, and the Lua traceback if the failure came from a script) for
+// run to report every broken input in one pass instead of crashing on the
+// first. See run's use of recordFailure.
+type EfemelError struct {
+	Filename     string
+	Phase        Phase
+	LuaTraceback string
+	Cause        error
+}
+
+func (e *EfemelError) Error() string {
+	if e.LuaTraceback != "" {
+		return fmt.Sprintf("%s (%s): %s\n%s", e.Filename, e.Phase, e.Cause, e.LuaTraceback)
+	}
+
+	return fmt.Sprintf("%s (%s): %s", e.Filename, e.Phase, e.Cause)
+}
+
+func (e *EfemelError) Unwrap() error {
+	return e.Cause
+}
+
+// newEfemelError wraps err as an EfemelError for filename/phase, lifting the
+// Lua traceback out of it when err (or something it wraps) is a
+// *lua.ApiError - gopher-lua already builds that traceback via
+// lua.LState.Where internally whenever a protected call fails.
+func newEfemelError(filename string, phase Phase, err error) *EfemelError {
+	traceback := ""
+
+	var apiErr *lua.ApiError
+	if errors.As(err, &apiErr) {
+		traceback = apiErr.StackTrace
+	}
+
+	return &EfemelError{
+		Filename:     filename,
+		Phase:        phase,
+		LuaTraceback: traceback,
+		Cause:        err,
+	}
+}
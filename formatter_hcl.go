@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"sort"
+
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	ctyjson "github.com/zclconf/go-cty/cty/json"
+)
+
+func newHCLFormatter(flags *flag.FlagSet, userSuffix string) (*Formatter, error) {
+	return &Formatter{Marshal: marshalHCL, suffix: getSuffix("hcl", userSuffix)}, nil
+}
+
+// marshalHCL renders v as a top-level HCL attribute list. It round-trips
+// through cty (via JSON, since cty's type system is a superset of JSON's)
+// because that's the value representation hclwrite.Body.SetAttributeValue
+// expects.
+func marshalHCL(v interface{}) ([]byte, error) {
+	jsonBytes, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	ctyType, err := ctyjson.ImpliedType(jsonBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	ctyValue, err := ctyjson.Unmarshal(jsonBytes, ctyType)
+	if err != nil {
+		return nil, err
+	}
+
+	if !ctyValue.Type().IsObjectType() {
+		return nil, fmt.Errorf("hcl output requires a top-level object, got %s", ctyValue.Type().FriendlyName())
+	}
+
+	file := hclwrite.NewEmptyFile()
+	body := file.Body()
+
+	attrs := ctyValue.AsValueMap()
+	keys := make([]string, 0, len(attrs))
+	for key := range attrs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		body.SetAttributeValue(key, attrs[key])
+	}
+
+	return file.Bytes(), nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// newTemplateFormatter renders the returned Lua map through a user-supplied
+// text/template, e.g. to emit Terraform HCL or any other text format from a
+// script that otherwise only has to return a plain table.
+func newTemplateFormatter(flags *flag.FlagSet, userSuffix string) (*Formatter, error) {
+	templatePath := flagString(flags, "template")
+	if templatePath == "" {
+		return nil, fmt.Errorf("--template is required for the template format")
+	}
+
+	templateSource, err := os.ReadFile(templatePath)
+	if err != nil {
+		return nil, fmt.Errorf("reading template %s: %w", templatePath, err)
+	}
+
+	tmpl, err := template.New(filepath.Base(templatePath)).Parse(string(templateSource))
+	if err != nil {
+		return nil, fmt.Errorf("parsing template %s: %w", templatePath, err)
+	}
+
+	defaultSuffix := strings.TrimPrefix(filepath.Ext(templatePath), ".")
+	if defaultSuffix == "" {
+		defaultSuffix = "txt"
+	}
+
+	return &Formatter{
+		suffix: getSuffix(defaultSuffix, userSuffix),
+		Marshal: func(v interface{}) ([]byte, error) {
+			var buf bytes.Buffer
+			if err := tmpl.Execute(&buf, v); err != nil {
+				return nil, err
+			}
+			return buf.Bytes(), nil
+		},
+	}, nil
+}
@@ -0,0 +1,11 @@
+package main
+
+import (
+	"flag"
+
+	toml "github.com/BurntSushi/toml"
+)
+
+func newTOMLFormatter(flags *flag.FlagSet, userSuffix string) (*Formatter, error) {
+	return &Formatter{Marshal: toml.Marshal, suffix: getSuffix("toml", userSuffix)}, nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"sort"
+)
+
+func newXMLFormatter(flags *flag.FlagSet, userSuffix string) (*Formatter, error) {
+	return &Formatter{Marshal: marshalXML, suffix: getSuffix("xml", userSuffix)}, nil
+}
+
+// marshalXML renders v as XML under a <root> element; a top-level array
+// becomes one <root> element per item instead. encoding/xml can't
+// marshal arbitrary map[string]interface{}/[]interface{} trees on its own,
+// so this walks the same shape luaTableToMap produces and emits tokens by hand.
+func marshalXML(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+
+	encoder := xml.NewEncoder(&buf)
+	encoder.Indent("", "  ")
+
+	if err := encodeXMLValue(encoder, xml.Name{Local: "root"}, v); err != nil {
+		return nil, err
+	}
+
+	if err := encoder.Flush(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func encodeXMLValue(encoder *xml.Encoder, name xml.Name, value interface{}) error {
+	switch typed := value.(type) {
+	case map[string]interface{}:
+		if err := encoder.EncodeToken(xml.StartElement{Name: name}); err != nil {
+			return err
+		}
+
+		keys := make([]string, 0, len(typed))
+		for key := range typed {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			if err := encodeXMLValue(encoder, xml.Name{Local: key}, typed[key]); err != nil {
+				return err
+			}
+		}
+
+		return encoder.EncodeToken(xml.EndElement{Name: name})
+	case []interface{}:
+		for _, item := range typed {
+			if err := encodeXMLValue(encoder, name, item); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return encoder.EncodeElement(fmt.Sprintf("%v", typed), xml.StartElement{Name: name})
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"flag"
 	"fmt"
 
 	"gopkg.in/yaml.v2"
@@ -12,6 +13,28 @@ type Formatter struct {
 	suffix  string
 }
 
+// FormatterFactory builds a Formatter for a format registered with
+// RegisterFormatter. It receives the CLI's flag.FlagSet so per-format
+// options (--indent, --template, --root-key, ...) are parsed the same way
+// regardless of which format is active.
+type FormatterFactory func(flags *flag.FlagSet, userSuffix string) (*Formatter, error)
+
+var formatterFactories = map[string]FormatterFactory{}
+
+// RegisterFormatter makes a format available to --format by name.
+func RegisterFormatter(name string, factory FormatterFactory) {
+	formatterFactories[name] = factory
+}
+
+func init() {
+	RegisterFormatter("json", newJSONFormatter)
+	RegisterFormatter("yaml", newYAMLFormatter)
+	RegisterFormatter("toml", newTOMLFormatter)
+	RegisterFormatter("hcl", newHCLFormatter)
+	RegisterFormatter("xml", newXMLFormatter)
+	RegisterFormatter("template", newTemplateFormatter)
+}
+
 func getSuffix(suffix string, defaultSuffix string) string {
 	if suffix != "" {
 		return suffix
@@ -19,20 +42,53 @@ func getSuffix(suffix string, defaultSuffix string) string {
 	return defaultSuffix
 }
 
-// Function that takes in a parameter called format and it looks in the struct
-// and either returns the formatter function or throws an error
-func getFormatter(format string, userSuffix string) (*Formatter, error) {
+func flagString(flags *flag.FlagSet, name string) string {
+	value := flags.Lookup(name)
+	if value == nil {
+		return ""
+	}
+	return value.Value.String()
+}
+
+// Function that takes in a parameter called format and it looks in the
+// registry and either returns the formatter function or throws an error
+func getFormatter(flags *flag.FlagSet, format string, userSuffix string) (*Formatter, error) {
 
 	if format == "" {
 		return nil, fmt.Errorf("output format not provided")
 	}
 
-	switch format {
-	case "json":
-		return &Formatter{Marshal: json.Marshal, suffix: getSuffix("json", userSuffix)}, nil
-	case "yaml":
-		return &Formatter{Marshal: yaml.Marshal, suffix: getSuffix("yaml", userSuffix)}, nil
-	default:
+	factory, ok := formatterFactories[format]
+	if !ok {
 		return nil, fmt.Errorf("unsupported format: %s", format)
 	}
+
+	formatter, err := factory(flags, userSuffix)
+	if err != nil {
+		return nil, err
+	}
+
+	if rootKey := flagString(flags, "root-key"); rootKey != "" {
+		marshal := formatter.Marshal
+		formatter.Marshal = func(v interface{}) ([]byte, error) {
+			return marshal(map[string]interface{}{rootKey: v})
+		}
+	}
+
+	return formatter, nil
+}
+
+func newJSONFormatter(flags *flag.FlagSet, userSuffix string) (*Formatter, error) {
+	marshal := json.Marshal
+	if indent := flagString(flags, "indent"); indent != "" {
+		marshal = func(v interface{}) ([]byte, error) {
+			return json.MarshalIndent(v, "", indent)
+		}
+	}
+
+	return &Formatter{Marshal: marshal, suffix: getSuffix("json", userSuffix)}, nil
+}
+
+func newYAMLFormatter(flags *flag.FlagSet, userSuffix string) (*Formatter, error) {
+	return &Formatter{Marshal: yaml.Marshal, suffix: getSuffix("yaml", userSuffix)}, nil
 }
@@ -3,9 +3,13 @@ package main
 import (
 	"fmt"
 	"os"
+	"path"
+	"sort"
 	"strings"
 	"sync"
 
+	fileprocessors "efemel/services/fileprocessors"
+
 	lua "github.com/yuin/gopher-lua"
 )
 
@@ -13,72 +17,343 @@ type LuaStateManager struct {
 	state          *lua.LState
 	addedPaths     map[string]bool
 	addedPathMutex sync.Mutex
+
+	// override, globalFunctions and globalTables are remembered so Reset can
+	// restore a checked-out-from-the-pool state to the same shape it had
+	// right after NewLuaStateManager, without paying for a brand new
+	// lua.LState (and re-running OpenLibs) on every job.
+	override           string
+	globalFunctions    map[string]func(L *lua.LState) int
+	globalTables       map[string]*lua.LTable
+	defaultPackagePath string
+
+	// deps collects every file the current job's script touched (itself,
+	// anything it required, anything read through efemel.fs) together with
+	// the backend each one should be read through, so --watch knows what to
+	// watch and hashDependencies knows how to re-read it. require()-resolved
+	// deps are always local (see searchPath); efemel.fs.read deps carry
+	// whichever backend that particular call actually resolved to.
+	deps      map[string]fileprocessors.FileProcessor
+	depsMutex sync.Mutex
+
+	// fsProcessor is the backend efemel.fs currently routes through. A
+	// pooled state is shared across jobs that may come from different VFS
+	// backends (file://, mem://, s3://, ...), so this is swapped per job by
+	// BeginJob rather than being fixed at construction.
+	fsProcessor      fileprocessors.FileProcessor
+	fsProcessorMutex sync.Mutex
 }
 
 type NewLuaStateManagerInput struct {
 	override string
+	// Registry lets efemel.fs resolve a path carrying an explicit
+	// "scheme://" prefix against a different backend than the job's own
+	// script, the same way a CLI input glob is resolved (see resolveFiles).
+	Registry *fileprocessors.Registry
+	// Modules are preloaded into package.preload so scripts can
+	// require("efemel.fs") / require("efemel.http") / require("efemel.path").
+	Modules []LuaModule
+}
+
+// requireWrapperScript installs a require() that, on top of the original:
+//   - merges an override module ("<name>-<override>") into the original
+//     module, falling back to the original when no override is configured
+//     or no override module exists.
+//   - reports every module name it resolves to __efemel_track_dependency
+//     (when that Go-backed global is present), so --watch can rebuild a
+//     script when one of its requires changes on disk.
+//
+// It is (re)applied both at construction and on every Reset, so a pooled
+// state can't leak a previous job's require tampering into the next one.
+const requireWrapperScript = `
+-- __efemel_original_require is captured once and reused on every
+-- reapplication (see Reset), so a pooled state never wraps its own
+-- previous wrapper: original_require always means Lua's real require.
+__efemel_original_require = __efemel_original_require or require
+local original_require = __efemel_original_require
+local override = %q
+
+__efemel_required = __efemel_required or {}
+
+function mergeTables(t1, t2)
+	for k, v in pairs(t2) do
+		if type(v) == "table" and type(t1[k]) == "table" then
+			t1[k] = mergeTables(t1[k], v)
+		else
+			t1[k] = v
+		end
+	end
+	return t1
+end
+
+function require(moduleName)
+	-- Relative requires can't be combined with the "<module>-<override>"
+	-- naming scheme below, but this wrapper now always runs (so every job's
+	-- dependencies get tracked), so only reject them when an override is
+	-- actually configured.
+	if override ~= "" and string.sub(moduleName, 1, 2) == "./" then
+		error("Relative paths are not supported")
+	end
+
+	__efemel_required[moduleName] = true
+
+	-- Go-backed modules (efemel.fs, efemel.http, ...) live in package.preload
+	-- with no file behind them, so searching the filesystem for them on every
+	-- call is both pointless and a wasted stat() per job.
+	if type(__efemel_track_dependency) == "function" and not package.preload[moduleName] and not package.loaded[moduleName] then
+		local resolvedPath = __efemel_searchpath(moduleName, package.path)
+		if resolvedPath then
+			__efemel_track_dependency(resolvedPath)
+		end
+	end
+
+	if override == "" then
+		return original_require(moduleName)
+	end
+
+	local overrideModuleName = moduleName .. "-" .. override
+
+	if package.loaded[overrideModuleName] then
+		return package.loaded[overrideModuleName]
+	end
+
+	local status, overrideModule = pcall(original_require, overrideModuleName)
+
+	originalModule = original_require(moduleName)
+
+	if not status then
+		return originalModule
+	end
+
+	if type(originalModule) == "table" and type(overrideModule) == "table" then
+		originalModule = mergeTables(originalModule, overrideModule)
+		return originalModule
+	end
+
+	return overrideModule
+end
+`
+
+func applyRequireWrapper(state *lua.LState, override string) error {
+	return state.DoString(fmt.Sprintf(requireWrapperScript, override))
 }
 
 func NewLuaStateManager(input NewLuaStateManagerInput) *LuaStateManager {
 
 	state := lua.NewState()
 
-	if input.override != "" {
-		wrapperScript := `
-		local original_require = require
-
-		function mergeTables(t1, t2)
-			for k, v in pairs(t2) do
-				if type(v) == "table" and type(t1[k]) == "table" then
-					t1[k] = mergeTables(t1[k], v)
-				else
-					t1[k] = v
-				end
-			end
-			return t1
-		end
+	if err := applyRequireWrapper(state, input.override); err != nil {
+		fmt.Println("Error:", err)
+		return nil
+	}
 
-		function require(moduleName)
-			-- Check if the module name starts with './'
-			if string.sub(moduleName, 1, 2) == "./" then
-				error("Relative paths are not supported")
-			end
-
-			local overrideModuleName = moduleName .. "-` + input.override + `"
-		
-			if package.loaded[overrideModuleName] then
-				return package.loaded[overrideModuleName]
-			end
-			
-			local status, overrideModule = pcall(original_require, overrideModuleName)
-			
-			originalModule = original_require(moduleName)
-		
-			if not status then
-				return originalModule
-			end
-		
-			if type(originalModule) == "table" and type(overrideModule) == "table" then
-				originalModule = mergeTables(originalModule, overrideModule)
-				return originalModule
-			end
-			
-			return overrideModule
-		end
-		`
-		if err := state.DoString(wrapperScript); err != nil {
-			fmt.Println("Error:", err)
-			return nil
+	defaultPackagePath := ""
+	if packageTable, ok := state.GetGlobal("package").(*lua.LTable); ok {
+		defaultPackagePath = packageTable.RawGetString("path").String()
+	}
+
+	manager := &LuaStateManager{
+		state:              state,
+		addedPaths:         make(map[string]bool),
+		addedPathMutex:     sync.Mutex{},
+		override:           input.override,
+		globalFunctions:    make(map[string]func(L *lua.LState) int),
+		globalTables:       make(map[string]*lua.LTable),
+		defaultPackagePath: defaultPackagePath,
+		deps:               make(map[string]fileprocessors.FileProcessor),
+	}
+
+	// efemel.fs is preloaded against the manager itself and the registry, not
+	// a fixed processor, so a bare path always reads whichever backend
+	// BeginJob most recently set (the current job's own script backend)
+	// while a "scheme://"-prefixed path can reach any other registered
+	// backend on a per-call basis.
+	state.PreloadModule("efemel.fs", NewFSModule(manager, input.Registry).Loader)
+
+	for _, module := range input.Modules {
+		state.PreloadModule(module.Name, module.Loader)
+	}
+
+	// require() always resolves against the local filesystem regardless of
+	// the script's own backend (see searchPath), so deps it reports are
+	// always local too.
+	localFSProcessor := fileprocessors.NewLocalFileProcessor()
+	state.SetGlobal("__efemel_track_dependency", state.NewFunction(func(L *lua.LState) int {
+		manager.RecordDependency(L.CheckString(1), localFSProcessor)
+		return 0
+	}))
+
+	state.SetGlobal("__efemel_searchpath", state.NewFunction(func(L *lua.LState) int {
+		resolved, ok := searchPath(L.CheckString(1), L.CheckString(2))
+		if !ok {
+			L.Push(lua.LNil)
+			return 1
 		}
+		L.Push(lua.LString(resolved))
+		return 1
+	}))
+
+	return manager
+}
+
+// searchPath is a minimal stand-in for Lua 5.2+'s package.searchpath, which
+// gopher-lua doesn't implement: it tries moduleName against each "?"
+// template in packagePath (';'-separated) and returns the first one that
+// exists on disk. Required modules are always resolved from the local
+// filesystem (see AddPath), regardless of which fileprocessors.FileProcessor
+// backend the script itself came from.
+func searchPath(moduleName, packagePath string) (string, bool) {
+	for _, template := range strings.Split(packagePath, ";") {
+		candidate := path.Clean(strings.ReplaceAll(template, "?", moduleName))
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate, true
+		}
+	}
+
+	return "", false
+}
+
+// BeginJob resets the dependency set to just scriptPath, ready to accumulate
+// whatever the upcoming job's script requires or reads via efemel.fs, and
+// points efemel.fs at processor - the backend this job's script actually
+// came from - so a pooled state shared across a mixed file://+s3://+mem://
+// run never leaks one job's backend into another's.
+func (l *LuaStateManager) BeginJob(scriptPath string, processor fileprocessors.FileProcessor) {
+	l.depsMutex.Lock()
+	l.deps = map[string]fileprocessors.FileProcessor{scriptPath: processor}
+	l.depsMutex.Unlock()
+
+	l.fsProcessorMutex.Lock()
+	l.fsProcessor = processor
+	l.fsProcessorMutex.Unlock()
+}
+
+// FSProcessor returns the backend a bare (scheme-less) efemel.fs path
+// should currently route through.
+func (l *LuaStateManager) FSProcessor() fileprocessors.FileProcessor {
+	l.fsProcessorMutex.Lock()
+	defer l.fsProcessorMutex.Unlock()
+	return l.fsProcessor
+}
+
+// RecordDependency adds path to the current job's dependency set, noting
+// processor as the backend it should be re-read through later. It is
+// called from the require wrapper (always the local backend) and from
+// efemel.fs.read (whichever backend that read actually resolved to).
+func (l *LuaStateManager) RecordDependency(path string, processor fileprocessors.FileProcessor) {
+	l.depsMutex.Lock()
+	defer l.depsMutex.Unlock()
+	l.deps[path] = processor
+}
+
+// Dependency pairs a recorded dependency path with the backend it should be
+// read through.
+type Dependency struct {
+	Path      string
+	Processor fileprocessors.FileProcessor
+}
+
+// Dependencies returns the path of every file recorded for the current job,
+// sorted. Used where only the path matters (--watch's fsnotify watches, the
+// cache file). See DependencySet for hashing, which also needs the backend.
+func (l *LuaStateManager) Dependencies() []string {
+	l.depsMutex.Lock()
+	defer l.depsMutex.Unlock()
+
+	deps := make([]string, 0, len(l.deps))
+	for path := range l.deps {
+		deps = append(deps, path)
 	}
+	sort.Strings(deps)
 
-	return &LuaStateManager{state: state, addedPaths: make(map[string]bool), addedPathMutex: sync.Mutex{}}
+	return deps
+}
+
+// DependencySet returns every file recorded for the current job paired with
+// the backend it was actually read through, sorted by path.
+func (l *LuaStateManager) DependencySet() []Dependency {
+	l.depsMutex.Lock()
+	defer l.depsMutex.Unlock()
+
+	deps := make([]Dependency, 0, len(l.deps))
+	for path, processor := range l.deps {
+		deps = append(deps, Dependency{Path: path, Processor: processor})
+	}
+	sort.Slice(deps, func(i, j int) bool { return deps[i].Path < deps[j].Path })
+
+	return deps
 }
 
 func (l *LuaStateManager) AddGlobalFunction(name string, function func(L *lua.LState) int) {
+	l.globalFunctions[name] = function
 	l.state.SetGlobal(name, l.state.NewFunction(function))
 }
 
+// Reset prepares a pooled LuaStateManager for reuse by a new job: it drops
+// package.loaded entries for modules required during the previous job,
+// restores package.path to what it was right after construction, reloads
+// the require wrapper (in case the previous script clobbered it) and
+// re-attaches every global function/table that was registered via
+// AddGlobalFunction/SetGlobalTable. The underlying lua.LState itself is
+// kept, avoiding the cost of rebuilding one (including OpenLibs and the
+// wrapper script) per job.
+func (l *LuaStateManager) Reset() error {
+	l.addedPathMutex.Lock()
+	l.addedPaths = make(map[string]bool)
+	l.addedPathMutex.Unlock()
+
+	l.depsMutex.Lock()
+	l.deps = make(map[string]fileprocessors.FileProcessor)
+	l.depsMutex.Unlock()
+
+	l.clearRequiredModules()
+
+	if packageTable, ok := l.state.GetGlobal("package").(*lua.LTable); ok {
+		packageTable.RawSetString("path", lua.LString(l.defaultPackagePath))
+	}
+
+	if err := applyRequireWrapper(l.state, l.override); err != nil {
+		return err
+	}
+
+	for name, function := range l.globalFunctions {
+		l.state.SetGlobal(name, l.state.NewFunction(function))
+	}
+
+	for name, table := range l.globalTables {
+		l.state.SetGlobal(name, table)
+	}
+
+	return nil
+}
+
+// clearRequiredModules drops package.loaded entries for every module the
+// wrapper script saw go through require() since the last Reset, so a stale
+// module from one job's script can't leak into the next job sharing this
+// pooled state.
+func (l *LuaStateManager) clearRequiredModules() {
+	required, ok := l.state.GetGlobal("__efemel_required").(*lua.LTable)
+	if !ok {
+		return
+	}
+
+	packageTable, ok := l.state.GetGlobal("package").(*lua.LTable)
+	if !ok {
+		return
+	}
+
+	loaded, ok := packageTable.RawGetString("loaded").(*lua.LTable)
+	if !ok {
+		return
+	}
+
+	required.ForEach(func(key, _ lua.LValue) {
+		loaded.RawSetString(key.String(), lua.LNil)
+	})
+
+	l.state.SetGlobal("__efemel_required", l.state.NewTable())
+}
+
 func (l *LuaStateManager) AddPath(path string) error {
 	l.addedPathMutex.Lock()
 	defer l.addedPathMutex.Unlock()
@@ -94,7 +369,12 @@ func (l *LuaStateManager) AddPath(path string) error {
 
 	path = strings.ReplaceAll(path, "\\", "\\\\")
 
-	err := l.state.DoString("package.path = package.path .. ';" + path + "\\?.lua'")
+	separator := "/"
+	if path == "" || strings.HasSuffix(path, "/") {
+		separator = ""
+	}
+
+	err := l.state.DoString("package.path = package.path .. ';" + path + separator + "\\?.lua'")
 
 	if err != nil {
 		return err
@@ -107,6 +387,7 @@ func (l *LuaStateManager) AddPath(path string) error {
 }
 
 func (l *LuaStateManager) SetGlobalTable(name string, table *lua.LTable) {
+	l.globalTables[name] = table
 	l.state.SetGlobal(name, table)
 }
 
@@ -146,7 +427,7 @@ func RunScript[T any](state *lua.LState, script string, processValue func(state
 }
 
 // get returned table from script
-func GetReturnedLuaTable(value lua.LValue) (*lua.LTable, error) {
+func GetReturnedLuaTable(state *lua.LState, value lua.LValue) (*lua.LTable, error) {
 	// Get the arguments from Lua
 	dataTable, ok := value.(*lua.LTable)
 
@@ -194,13 +475,13 @@ func GetReturnedMap(state *lua.LState, value lua.LValue) (interface{}, error) {
     }
 
 	// Get the arguments from Lua
-	dataTable, err := GetReturnedLuaTable(value)
+	dataTable, err := GetReturnedLuaTable(state, value)
 
 	if err != nil {
 		return nil, err
 	}
 
-	return luaTableToMap(state, dataTable), nil
+	return luaTableToMap(state, dataTable)
 }
 
 // get returned string from script
@@ -215,48 +496,76 @@ func GetReturnedString(value lua.LValue) (string, error) {
 	return string(dataString), nil
 }
 
-// Function to recursively convert Lua table to Go map
-func luaValueToInterface(state *lua.LState, value lua.LValue) interface{} {
+// Function to recursively convert Lua table to Go map. A nested function
+// value that errors when called is reported back as an error rather than
+// panicking - this runs inside a worker goroutine with nothing above it to
+// recover, so a panic here used to take down the whole process instead of
+// just failing the one job.
+func luaValueToInterface(state *lua.LState, value lua.LValue) (interface{}, error) {
 	switch value.Type() {
 	case lua.LTBool:
-		return bool(value.(lua.LBool))
+		return bool(value.(lua.LBool)), nil
 	case lua.LTNumber:
-		return float64(value.(lua.LNumber))
+		return float64(value.(lua.LNumber)), nil
 	case lua.LTString:
-		return string(value.(lua.LString))
+		return string(value.(lua.LString)), nil
 	case lua.LTTable:
 		return luaTableToMap(state, value.(*lua.LTable))
 	case lua.LTFunction:
-		newValue, error := RunReturnedLuaFunction(state, value)
+		newValue, err := RunReturnedLuaFunction(state, value)
 
-		if error != nil {
-			panic(error)
+		if err != nil {
+			return nil, err
 		}
 
-		return newValue
+		return newValue, nil
 	default:
-		return nil
+		return nil, nil
 	}
 }
 
 // convert Lua table to Go interface
-func luaTableToMap(state *lua.LState, table *lua.LTable) interface{} {
+func luaTableToMap(state *lua.LState, table *lua.LTable) (interface{}, error) {
 	if table.MaxN() > 0 {
 		// If the table has sequential integer keys starting from 1, treat it as an array
 		arr := make([]interface{}, table.MaxN())
+		var forEachErr error
 		table.ForEach(func(i lua.LValue, value lua.LValue) {
+			if forEachErr != nil {
+				return
+			}
+			converted, err := luaValueToInterface(state, value)
+			if err != nil {
+				forEachErr = err
+				return
+			}
 			idx := int(i.(lua.LNumber))
-			arr[idx-1] = luaValueToInterface(state, value)
+			arr[idx-1] = converted
 		})
-		return arr
+		if forEachErr != nil {
+			return nil, forEachErr
+		}
+		return arr, nil
 	}
 
 	// If not, treat it as a map
 	result := make(map[string]interface{})
 
+	var forEachErr error
 	table.ForEach(func(key, value lua.LValue) {
-		result[key.String()] = luaValueToInterface(state, value)
+		if forEachErr != nil {
+			return
+		}
+		converted, err := luaValueToInterface(state, value)
+		if err != nil {
+			forEachErr = err
+			return
+		}
+		result[key.String()] = converted
 	})
+	if forEachErr != nil {
+		return nil, forEachErr
+	}
 
-	return result
+	return result, nil
 }
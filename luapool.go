@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// LuaStatePool bounds the number of live LuaStateManager instances to at
+// most maxSize, building them lazily and handing them out per job instead
+// of paying the cost of a fresh lua.LState (OpenLibs plus the require
+// wrapper script) on every worker startup.
+type LuaStatePool struct {
+	builder func() *LuaStateManager
+	states  chan *LuaStateManager
+	maxSize int
+
+	mu      sync.Mutex
+	created int
+
+	reuseCount uint64
+}
+
+// NewLuaStatePool creates a pool that lazily builds up to maxSize
+// LuaStateManagers via builder.
+func NewLuaStatePool(maxSize int, builder func() *LuaStateManager) *LuaStatePool {
+	return &LuaStatePool{
+		builder: builder,
+		states:  make(chan *LuaStateManager, maxSize),
+		maxSize: maxSize,
+	}
+}
+
+// Get checks out a LuaStateManager: an idle one returned via Put if there is
+// one, otherwise a freshly built one while fewer than maxSize have been
+// built, otherwise it blocks until ctx is done or a state is returned.
+func (p *LuaStatePool) Get(ctx context.Context) (*LuaStateManager, error) {
+	select {
+	case manager := <-p.states:
+		atomic.AddUint64(&p.reuseCount, 1)
+		return manager, nil
+	default:
+	}
+
+	p.mu.Lock()
+	if p.created < p.maxSize {
+		p.created++
+		p.mu.Unlock()
+		return p.builder(), nil
+	}
+	p.mu.Unlock()
+
+	select {
+	case manager := <-p.states:
+		atomic.AddUint64(&p.reuseCount, 1)
+		return manager, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Put resets manager and returns it to the pool for reuse by the next Get.
+func (p *LuaStatePool) Put(manager *LuaStateManager) {
+	if err := manager.Reset(); err != nil {
+		manager.Close()
+		return
+	}
+
+	select {
+	case p.states <- manager:
+	default:
+		// the pool is already full; nothing to do but free this one.
+		manager.Close()
+	}
+}
+
+// ReuseCount reports how many Get calls were served by a recycled state
+// instead of a freshly built one.
+func (p *LuaStatePool) ReuseCount() uint64 {
+	return atomic.LoadUint64(&p.reuseCount)
+}
+
+// Close closes every LuaStateManager currently checked into the pool.
+func (p *LuaStatePool) Close() {
+	close(p.states)
+	for manager := range p.states {
+		manager.Close()
+	}
+}
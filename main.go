@@ -1,10 +1,13 @@
 package main
 
 import (
+	"context"
 	fileprocessors "efemel/services/fileprocessors"
 	"flag"
 	"fmt"
 	"sync"
+	"sync/atomic"
+	"syscall"
 
 	lua "github.com/yuin/gopher-lua"
 )
@@ -14,6 +17,14 @@ type FileData struct {
 	FilePath       string
 	OutputFilename string
 	Data           []byte
+	// Processor is the backend the rendered output will be written to,
+	// resolved from --output-path's URI scheme (file://, mem://, s3://, ...).
+	Processor      fileprocessors.FileProcessor
+	// InputProcessor is the backend this job's script (and thus its
+	// dependency set) was read from, which may differ from Processor when the
+	// input globs use a different scheme than --output-path. Used to hash the
+	// job's deps.
+	InputProcessor fileprocessors.FileProcessor
 }
 
 type OutputFileData struct {
@@ -21,6 +32,14 @@ type OutputFileData struct {
 	FilePath       string
 	OutputFilename string
 	Data           interface{}
+	// Processor is the backend the rendered output should be written to,
+	// resolved from --output-path's URI scheme.
+	Processor      fileprocessors.FileProcessor
+	// Deps and Hash describe what this job's output was built from, so the
+	// writer can skip unchanged outputs and --watch knows what to watch.
+	// See watch.go.
+	Deps []string
+	Hash string
 }
 
 // Define a Go function that you want to expose to Lua
@@ -38,35 +57,97 @@ func luaAdd(L *lua.LState) int {
 	return 1
 }
 
+// ResolvedFile pairs an input filename with the backend it was found on, so
+// input globs using several schemes can still be processed by a single run.
+type ResolvedFile struct {
+	Filename  string
+	Processor fileprocessors.FileProcessor
+}
+
 type RunInput struct {
-	fileProcessor fileprocessors.FileProcessor
+	files         []ResolvedFile
 	formatter     Formatter
-	luaStateManagerBuilder func() *LuaStateManager
-	filenames	 []string
+	luaStatePool  *LuaStatePool
 	inputChannelBufferSize int
 	outputChannelBufferSize int
 	workerCount int
 	writerCount int
-	outputFilePath string
+	outputProcessor fileprocessors.FileProcessor
+	outputPath      string
 	dryRun bool
+	// cache and cacheMutex are shared across possibly-repeated calls to run
+	// (e.g. --watch's rebuilds), so unchanged outputs keep getting skipped.
+	cache      Cache
+	cacheMutex *sync.Mutex
+	// failFast stops the run at the first job failure instead of finishing
+	// every other job and reporting them all together.
+	failFast bool
 }
 
-func run(input RunInput) {
+// run processes every input file and returns how many completed
+// successfully and the failures it hit along the way, instead of panicking
+// on the first bad job - a broken script, a missing dependency, or a failed
+// write shouldn't lose progress on every other file in the glob. succeeded
+// only counts jobs actually confirmed done; under --fail-fast some selected
+// files may end up as neither, having never been attempted.
+func run(input RunInput) (succeeded int, failures []*EfemelError) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var failuresMutex sync.Mutex
+	var succeededCount int64
+
+	recordFailure := func(err *EfemelError) {
+		failuresMutex.Lock()
+		failures = append(failures, err)
+		failuresMutex.Unlock()
+
+		if input.failFast {
+			cancel()
+		}
+	}
+
 	// Initialize Lua workers
 	worker := func(id int, jobs <-chan FileData, results chan<- OutputFileData, wg *sync.WaitGroup) {
-		// workers can't share the same Lua state, so we need to create a new one for each worker
-		luaStateManager := input.luaStateManagerBuilder()
-
 		defer wg.Done()
 
 		for job := range jobs {
+			if ctx.Err() != nil {
+				// --fail-fast cancelled the run - pool.Get would often still
+				// hand back an already-checked-out state without ever
+				// consulting ctx, so bail out explicitly instead of relying
+				// on it to notice.
+				return
+			}
+
+			luaStateManager, err := input.luaStatePool.Get(ctx)
+
+			if err != nil {
+				// the pool couldn't hand out a state (--fail-fast cancelled,
+				// or the run is shutting down) - nothing left to do for the
+				// remaining jobs either.
+				return
+			}
+
 			// to handle relative imports to the file
 			luaStateManager.AddPath(job.FilePath)
+			luaStateManager.BeginJob(job.Filename, job.InputProcessor)
 
 			res, err := RunScript(luaStateManager.state, string(job.Data), GetReturnedMap)
+			deps := luaStateManager.Dependencies()
+			depSet := luaStateManager.DependencySet()
+
+			input.luaStatePool.Put(luaStateManager)
 
 			if err != nil {
-				panic(err)
+				recordFailure(newEfemelError(job.Filename, PhaseExecute, err))
+				continue
+			}
+
+			hash, err := hashDependencies(depSet)
+			if err != nil {
+				recordFailure(newEfemelError(job.Filename, PhaseExecute, err))
+				continue
 			}
 
 			results <- OutputFileData{
@@ -74,11 +155,13 @@ func run(input RunInput) {
 				FilePath:       job.FilePath,
 				OutputFilename: job.OutputFilename,
 				Data:           res,
+				Processor:      job.Processor,
+				Deps:           deps,
+				Hash:           hash,
 			}
 		}
 
 		fmt.Println("worker", id, "shutting down")
-		luaStateManager.Close()
 	}
 
 	dataInputChannel := make(chan FileData, input.inputChannelBufferSize)
@@ -97,24 +180,36 @@ func run(input RunInput) {
 		defer close(dataInputChannel)
 
 		// loop through the filenames and process each one in a separate goroutine
-		for _, filename := range input.filenames {
+		for _, file := range input.files {
+			if ctx.Err() != nil {
+				return
+			}
 
-			fmt.Printf("Processing %s\n", filename)
+			fmt.Printf("Processing %s\n", file.Filename)
 
-			script, err := input.fileProcessor.ReadFile(filename)
+			script, err := file.Processor.ReadFile(file.Filename)
 
 			if err != nil {
-				fmt.Println("Error:", err)
-				return
+				recordFailure(newEfemelError(file.Filename, PhaseRead, err))
+				continue
 			}
 
-			outputFileName := generateOutputFilename(input.outputFilePath, filename, input.formatter.suffix)
+			outputFileName := generateOutputFilename(input.outputPath, file.Filename, input.formatter.suffix)
 
-			dataInputChannel <- FileData{
-				Filename:       filename,
-				FilePath:       input.fileProcessor.GetPathToFile(filename),
+			// A select (rather than a plain send) so --fail-fast's cancel
+			// can't leave this goroutine blocked forever once every worker
+			// has already stopped draining dataInputChannel.
+			select {
+			case dataInputChannel <- FileData{
+				Filename:       file.Filename,
+				FilePath:       file.Processor.GetPathToFile(file.Filename),
 				OutputFilename: outputFileName,
 				Data:           script,
+				Processor:      input.outputProcessor,
+				InputProcessor: file.Processor,
+			}:
+			case <-ctx.Done():
+				return
 			}
 		}
 	}()
@@ -129,8 +224,9 @@ func run(input RunInput) {
 	if input.dryRun {
 		for fileData := range dataOutputChannel {
 			fmt.Println(string(fileData.Filename))
+			atomic.AddInt64(&succeededCount, 1)
 		}
-		return
+		return int(succeededCount), failures
 	}
 
 	// Write files
@@ -141,25 +237,63 @@ func run(input RunInput) {
 		go func() {
 			defer writeWaitGroup.Done()
 			for fileData := range dataOutputChannel {
+				input.cacheMutex.Lock()
+				previous, cached := input.cache[fileData.OutputFilename]
+				input.cacheMutex.Unlock()
+
+				if cached && previous.Hash == fileData.Hash {
+					fmt.Println("Unchanged, skipping", fileData.OutputFilename)
+					atomic.AddInt64(&succeededCount, 1)
+					continue
+				}
+
 				formattedData, err := input.formatter.Marshal(fileData.Data)
 
 				if err != nil {
-					panic(err)
+					recordFailure(newEfemelError(fileData.Filename, PhaseMarshal, err))
+					continue
 				}
 
 				fmt.Println("Writing", fileData.OutputFilename)
 
-				if err :=  input.fileProcessor.WriteFile(fileData.OutputFilename, formattedData); err != nil {
-					panic(err)
+				if err := fileData.Processor.WriteFile(fileData.OutputFilename, formattedData); err != nil {
+					recordFailure(newEfemelError(fileData.Filename, PhaseWrite, err))
+					continue
+				}
+
+				input.cacheMutex.Lock()
+				input.cache[fileData.OutputFilename] = CacheEntry{
+					Filename: fileData.Filename,
+					Deps:     fileData.Deps,
+					Hash:     fileData.Hash,
 				}
+				input.cacheMutex.Unlock()
+
+				atomic.AddInt64(&succeededCount, 1)
 			}
 		}()
 	}
 
 	writeWaitGroup.Wait()
+
+	fmt.Println("Lua states reused", input.luaStatePool.ReuseCount(), "times")
+
+	return int(succeededCount), failures
 }
 
-func loadGlobalVars(fileProcessor fileprocessors.FileProcessor, varsFile string) (lua.LTable, error) {
+// reportFailures prints a grouped summary of every failure run collected, so
+// a broken glob surfaces all of its problems - and their Lua tracebacks - in
+// one pass instead of stopping at the first one.
+func reportFailures(succeeded int, failures []*EfemelError) {
+	fmt.Printf("%d succeeded, %d failed\n", succeeded, len(failures))
+
+	for _, failure := range failures {
+		fmt.Println("---")
+		fmt.Println(failure)
+	}
+}
+
+func loadGlobalVars(registry *fileprocessors.Registry, fileProcessor fileprocessors.FileProcessor, varsFile string) (lua.LTable, error) {
 
 	if varsFile == "" {
 		return null[lua.LTable](), nil
@@ -168,9 +302,14 @@ func loadGlobalVars(fileProcessor fileprocessors.FileProcessor, varsFile string)
 	varsScript := string(handleError(fileProcessor.ReadFile(varsFile)))
 	varsPath := fileProcessor.GetPathToFile(varsFile)
 
-	luaStateManager := NewLuaStateManager()
+	luaStateManager := NewLuaStateManager(NewLuaStateManagerInput{Registry: registry})
 	defer luaStateManager.Close()
 
+	// Gives --vars scripts a real efemel.fs backend too (the same one the
+	// vars file itself was read from), instead of leaving fsProcessor nil and
+	// crashing the process the first time such a script calls efemel.fs.
+	luaStateManager.BeginJob(varsFile, fileProcessor)
+
 	luaStateManager.AddPath(varsPath)
 
 	value, err := RunScript(luaStateManager.state, varsScript, GetReturnedLuaTable)
@@ -182,6 +321,66 @@ func loadGlobalVars(fileProcessor fileprocessors.FileProcessor, varsFile string)
 	return *value, nil
 }
 
+// newDefaultRegistry builds the scheme -> FileProcessor registry efemel
+// ships with: "file" (also the default for scheme-less paths) and "mem" are
+// always available, "s3" is lazily built from AWS_* environment variables
+// the first time an "s3://" URI is resolved, and "http"/"https" are
+// read-only.
+func newDefaultRegistry() *fileprocessors.Registry {
+	registry := fileprocessors.NewRegistry()
+
+	registry.Register("file", func() (fileprocessors.FileProcessor, error) {
+		return fileprocessors.NewLocalFileProcessor(), nil
+	})
+	registry.Register("mem", func() (fileprocessors.FileProcessor, error) {
+		return fileprocessors.NewMemoryFileProcessor(), nil
+	})
+	registry.Register("http", func() (fileprocessors.FileProcessor, error) {
+		return fileprocessors.NewHTTPFileProcessor("http"), nil
+	})
+	registry.Register("https", func() (fileprocessors.FileProcessor, error) {
+		return fileprocessors.NewHTTPFileProcessor("https"), nil
+	})
+	registry.Register("s3", func() (fileprocessors.FileProcessor, error) {
+		return fileprocessors.NewS3FileProcessor()
+	})
+
+	return registry
+}
+
+// resolveFiles expands each input glob against the backend its URI scheme
+// resolves to, so a single invocation can mix "s3://bucket/*.lua" with
+// plain local globs. A literal file is tried first via Exists, since some
+// backends (e.g. "http(s)://", which has no directory listing) can serve a
+// single file directly but always reject FindFiles; only once that fails do
+// we fall back to globbing.
+func resolveFiles(registry *fileprocessors.Registry, globs []string) ([]ResolvedFile, error) {
+	var result []ResolvedFile
+
+	for _, glob := range globs {
+		processor, rest, err := registry.Resolve(glob)
+		if err != nil {
+			return nil, err
+		}
+
+		if exists, err := processor.Exists(rest); err == nil && exists {
+			result = append(result, ResolvedFile{Filename: rest, Processor: processor})
+			continue
+		}
+
+		filenames, err := processor.FindFiles([]string{rest})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, filename := range filenames {
+			result = append(result, ResolvedFile{Filename: filename, Processor: processor})
+		}
+	}
+
+	return result, nil
+}
+
 func main() {
 
 	// Define command-line flags
@@ -194,6 +393,11 @@ func main() {
 	inputChannelBufferSize := flag.Int("input-buffer", 10, "Input channel buffer size")
 	outputChannelBufferSize := flag.Int("output-buffer", 10, "Output channel buffer size")
 	outputFilePath := flag.String("output-path", "./build", "Output path")
+	watch := flag.Bool("watch", false, "Watch input scripts and their dependencies, rebuilding affected outputs on change")
+	failFast := flag.Bool("fail-fast", false, "Stop at the first failing job instead of finishing the rest and reporting them all together")
+	flag.String("template", "", "Path to a text/template used by the \"template\" format")
+	flag.String("indent", "", "Indent string used by formats that support it (e.g. json)")
+	flag.String("root-key", "", "Wrap the rendered data under this key before formatting")
 	flag.Parse()
 
 	// Check if output file is provided
@@ -202,23 +406,41 @@ func main() {
 		return
 	}
 
-	fileProcessor := fileprocessors.NewLocalFileProcessor()
-	filenames := handleError(fileProcessor.FindFiles(flag.Args()))
-	formatter := handleError(getFormatter(*outputFormat, *outputFileExtension))
-
+	registry := newDefaultRegistry()
 
-	globalVars, err := loadGlobalVars(fileProcessor, *varsFile)
+	files := handleError(resolveFiles(registry, flag.Args()))
+	formatter := handleError(getFormatter(flag.CommandLine, *outputFormat, *outputFileExtension))
 
+	outputProcessor, outputPath, err := registry.Resolve(*outputFilePath)
 	if err != nil {
-		panic(err) 
+		panic(err)
+	}
+
+	var globalVars lua.LTable
+	if *varsFile != "" {
+		varsProcessor, varsPath, err := registry.Resolve(*varsFile)
+		if err != nil {
+			panic(err)
+		}
+
+		globalVars, err = loadGlobalVars(registry, varsProcessor, varsPath)
+		if err != nil {
+			panic(err)
+		}
 	}
 
 	luaStateManagerBuilder := func () *LuaStateManager {
-		luaStateManager := NewLuaStateManager()
+		luaStateManager := NewLuaStateManager(NewLuaStateManagerInput{
+			Registry: registry,
+			Modules: []LuaModule{
+				NewHTTPModule(),
+				NewPathModule(),
+			},
+		})
 
 		luaStateManager.AddGlobalFunction("testAdd", luaAdd)
 
-		if varsFile != nil {
+		if *varsFile != "" {
 			// validate that Lua state doesn't have a global variable with the same name
 			// custom modules could accidentally overwrite the global variable
 			existingGlobalVars := luaStateManager.state.GetGlobal("vars")
@@ -232,18 +454,57 @@ func main() {
 		return luaStateManager
 	}
 
-	run(RunInput{
-		fileProcessor: fileProcessor,
-		formatter:     *formatter,
-		luaStateManagerBuilder: luaStateManagerBuilder,
-		filenames:     filenames,
-		inputChannelBufferSize: *inputChannelBufferSize,
-		outputChannelBufferSize: *outputChannelBufferSize,
-		workerCount: *workerCount,
-		writerCount: *writerCount,
-		outputFilePath: *outputFilePath,
-		dryRun: *dryRun,
-	})
+	// states are built lazily up to --workers and reused across jobs instead
+	// of paying for a fresh lua.LState (and the require wrapper) per job.
+	luaStatePool := NewLuaStatePool(*workerCount, luaStateManagerBuilder)
+	defer luaStatePool.Close()
+
+	cachePath := cachePathFor(outputPath)
+	cache := loadCache(outputProcessor, cachePath)
+	cacheMutex := &sync.Mutex{}
+
+	build := func(selected []ResolvedFile) []*EfemelError {
+		succeeded, failures := run(RunInput{
+			files:         selected,
+			formatter:     *formatter,
+			luaStatePool:  luaStatePool,
+			inputChannelBufferSize: *inputChannelBufferSize,
+			outputChannelBufferSize: *outputChannelBufferSize,
+			workerCount: *workerCount,
+			writerCount: *writerCount,
+			outputProcessor: outputProcessor,
+			outputPath:      outputPath,
+			dryRun: *dryRun,
+			cache:         cache,
+			cacheMutex:    cacheMutex,
+			failFast:      *failFast,
+		})
+
+		if !*dryRun {
+			if err := saveCache(outputProcessor, cachePath, cache); err != nil {
+				fmt.Println("Error saving cache:", err)
+			}
+		}
+
+		reportFailures(succeeded, failures)
+
+		return failures
+	}
+
+	failures := build(files)
 
 	fmt.Println("All jobs are done")
+
+	if *watch && !*dryRun {
+		if err := watchAndRebuild(files, cache, cacheMutex, func(selected []ResolvedFile) {
+			build(selected)
+		}); err != nil {
+			fmt.Println("Error watching for changes:", err)
+		}
+		return
+	}
+
+	if len(failures) > 0 {
+		syscall.Exit(1)
+	}
 }
@@ -0,0 +1,96 @@
+package fileprocessors
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"time"
+)
+
+// HTTPFileProcessor implements the FileProcessor interface for the
+// "http://" and "https://" schemes. It is read-only: efemel scripts and
+// their dependencies can be fetched over HTTP, but there is no remote
+// target to write rendered output to.
+type HTTPFileProcessor struct {
+	client *http.Client
+	scheme string
+}
+
+// creates a new HTTPFileProcessor for the given scheme ("http" or "https")
+func NewHTTPFileProcessor(scheme string) *HTTPFileProcessor {
+	return &HTTPFileProcessor{
+		client: &http.Client{Timeout: 30 * time.Second},
+		scheme: scheme,
+	}
+}
+
+// ReadFile fetches filePath (the part of the URI after "http(s)://") over HTTP.
+func (h *HTTPFileProcessor) ReadFile(filePath string) ([]byte, error) {
+	url := h.scheme + "://" + filePath
+
+	resp, err := h.client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: unexpected status %s", url, resp.Status)
+	}
+
+	return io.ReadAll(resp.Body)
+}
+
+// FindFiles is unsupported: HTTP has no directory listing to glob over.
+func (h *HTTPFileProcessor) FindFiles(globs []string) ([]string, error) {
+	return nil, fmt.Errorf("%s:// does not support globbing, reference files directly", h.scheme)
+}
+
+// WriteFile is unsupported: the http(s) backend is read-only.
+func (h *HTTPFileProcessor) WriteFile(filename string, data []byte) error {
+	return fmt.Errorf("%s:// is a read-only backend, cannot write %s", h.scheme, filename)
+}
+
+// get path to file
+func (h *HTTPFileProcessor) GetPathToFile(filename string) string {
+	return path.Dir(filename)
+}
+
+// Exists issues a HEAD request to check whether filePath resolves to a 200.
+func (h *HTTPFileProcessor) Exists(filePath string) (bool, error) {
+	resp, err := h.client.Head(h.scheme + "://" + filePath)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	return resp.StatusCode == http.StatusOK, nil
+}
+
+// Stat issues a HEAD request and reports Content-Length as Size.
+func (h *HTTPFileProcessor) Stat(filePath string) (FileInfo, error) {
+	url := h.scheme + "://" + filePath
+
+	resp, err := h.client.Head(url)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return FileInfo{}, fmt.Errorf("HEAD %s: unexpected status %s", url, resp.Status)
+	}
+
+	return FileInfo{Name: path.Base(filePath), Size: resp.ContentLength}, nil
+}
+
+// ReadDir is unsupported: HTTP has no directory listing.
+func (h *HTTPFileProcessor) ReadDir(dirPath string) ([]string, error) {
+	return nil, fmt.Errorf("%s:// does not support directory listing", h.scheme)
+}
+
+// Realpath is a no-op for the http(s) backend: URLs are already canonical.
+func (h *HTTPFileProcessor) Realpath(filePath string) (string, error) {
+	return filePath, nil
+}
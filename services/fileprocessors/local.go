@@ -67,4 +67,52 @@ func (l *LocalFileProcessor) WriteFile(filename string, data []byte) error {
 // get path to file
 func (l *LocalFileProcessor) GetPathToFile(filename string) string {
     return filepath.Dir(filename)
+}
+
+// Exists reports whether filePath exists on local storage.
+func (l *LocalFileProcessor) Exists(filePath string) (bool, error) {
+    if _, err := os.Stat(filePath); err != nil {
+        if os.IsNotExist(err) {
+            return false, nil
+        }
+        return false, err
+    }
+    return true, nil
+}
+
+// Stat returns metadata about a local file or directory.
+func (l *LocalFileProcessor) Stat(filePath string) (FileInfo, error) {
+    info, err := os.Stat(filePath)
+    if err != nil {
+        return FileInfo{}, err
+    }
+    return FileInfo{
+        Name:    info.Name(),
+        Size:    info.Size(),
+        IsDir:   info.IsDir(),
+        ModTime: info.ModTime().Unix(),
+    }, nil
+}
+
+// ReadDir lists the immediate entries of a local directory.
+func (l *LocalFileProcessor) ReadDir(dirPath string) ([]string, error) {
+    entries, err := os.ReadDir(dirPath)
+    if err != nil {
+        return nil, err
+    }
+
+    names := make([]string, len(entries))
+    for i, entry := range entries {
+        names[i] = entry.Name()
+    }
+    return names, nil
+}
+
+// Realpath resolves filePath to an absolute, symlink-free local path.
+func (l *LocalFileProcessor) Realpath(filePath string) (string, error) {
+    absPath, err := filepath.Abs(filePath)
+    if err != nil {
+        return "", err
+    }
+    return filepath.EvalSymlinks(absPath)
 }
\ No newline at end of file
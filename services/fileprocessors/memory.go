@@ -3,16 +3,30 @@ package fileprocessors
 import (
 	"fmt"
 	"path/filepath"
+	"strings"
+	"sync"
 )
 
 // MemoryFileProcessor implements the FileProcessor interface for file processing in memory.
 type MemoryFileProcessor struct {
     // Any specific configuration or dependencies can be added here.
     data map[string][]byte // For simplicity, using a map to store file data in memory
+    // mutex guards data: the Registry hands the same instance to every job
+    // targeting a given mem:// root, and run's workers/writers read and
+    // write it concurrently.
+    mutex sync.RWMutex
+}
+
+// creates a new MemoryFileProcessor, backing the "mem://" scheme
+func NewMemoryFileProcessor() *MemoryFileProcessor {
+    return &MemoryFileProcessor{data: make(map[string][]byte)}
 }
 
 // ReadFile reads a file from memory.
 func (m *MemoryFileProcessor) ReadFile(filePath string) ([]byte, error) {
+    m.mutex.RLock()
+    defer m.mutex.RUnlock()
+
     // Check if file exists in memory
     if data, ok := m.data[filePath]; ok {
         return data, nil
@@ -22,6 +36,9 @@ func (m *MemoryFileProcessor) ReadFile(filePath string) ([]byte, error) {
 
 // FindFiles finds all files matching a glob pattern in memory.
 func (m *MemoryFileProcessor) FindFiles(globs []string) ([]string, error) {
+    m.mutex.RLock()
+    defer m.mutex.RUnlock()
+
     var result []string
     for _, glob := range globs {
         for filePath := range m.data {
@@ -41,7 +58,69 @@ func (m *MemoryFileProcessor) FindFiles(globs []string) ([]string, error) {
 
 // WriteFile writes data to memory.
 func (m *MemoryFileProcessor) WriteFile(filePath string, data []byte) error {
+    m.mutex.Lock()
+    defer m.mutex.Unlock()
+
     // Write the data to memory
     m.data[filePath] = data
     return nil
-}
\ No newline at end of file
+}
+
+// get path to file
+func (m *MemoryFileProcessor) GetPathToFile(filename string) string {
+    return filepath.Dir(filename)
+}
+
+// Exists reports whether filePath was written to memory.
+func (m *MemoryFileProcessor) Exists(filePath string) (bool, error) {
+    m.mutex.RLock()
+    defer m.mutex.RUnlock()
+
+    _, ok := m.data[filePath]
+    return ok, nil
+}
+
+// Stat returns metadata about an in-memory file.
+func (m *MemoryFileProcessor) Stat(filePath string) (FileInfo, error) {
+    m.mutex.RLock()
+    defer m.mutex.RUnlock()
+
+    data, ok := m.data[filePath]
+    if !ok {
+        return FileInfo{}, fmt.Errorf("file not found in memory: %s", filePath)
+    }
+    return FileInfo{Name: filepath.Base(filePath), Size: int64(len(data))}, nil
+}
+
+// ReadDir lists the in-memory entries directly under dirPath.
+func (m *MemoryFileProcessor) ReadDir(dirPath string) ([]string, error) {
+    m.mutex.RLock()
+    defer m.mutex.RUnlock()
+
+    prefix := strings.TrimSuffix(dirPath, "/") + "/"
+
+    seen := make(map[string]bool)
+    var names []string
+
+    for filePath := range m.data {
+        if !strings.HasPrefix(filePath, prefix) {
+            continue
+        }
+
+        rest := strings.TrimPrefix(filePath, prefix)
+        name := strings.SplitN(rest, "/", 2)[0]
+
+        if !seen[name] {
+            seen[name] = true
+            names = append(names, name)
+        }
+    }
+
+    return names, nil
+}
+
+// Realpath only cleans filePath lexically (filepath.Clean): the in-memory
+// backend has no symlinks or working directory to resolve against.
+func (m *MemoryFileProcessor) Realpath(filePath string) (string, error) {
+    return filepath.Clean(filePath), nil
+}
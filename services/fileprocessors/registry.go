@@ -0,0 +1,77 @@
+package fileprocessors
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// FileProcessorFactory lazily builds the FileProcessor responsible for a
+// single URI scheme. It is only invoked the first time that scheme is
+// resolved by a Registry.
+type FileProcessorFactory func() (FileProcessor, error)
+
+// Registry maps a URI scheme ("file", "mem", "s3", "http", "https", ...) to
+// the FileProcessor that backs it, so a single efemel invocation can read
+// Lua scripts from one backend (e.g. "s3://") and write rendered output to
+// another (e.g. "file://" or the implicit local default).
+type Registry struct {
+	factories map[string]FileProcessorFactory
+	cache     map[string]FileProcessor
+	mu        sync.Mutex
+}
+
+// NewRegistry creates an empty scheme -> FileProcessor registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		factories: make(map[string]FileProcessorFactory),
+		cache:     make(map[string]FileProcessor),
+	}
+}
+
+// Register associates a URI scheme with the factory used to build its
+// FileProcessor. Registering the same scheme twice replaces the factory and
+// drops any already-cached instance.
+func (r *Registry) Register(scheme string, factory FileProcessorFactory) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.factories[scheme] = factory
+	delete(r.cache, scheme)
+}
+
+// Resolve splits a "scheme://rest" URI and returns the FileProcessor
+// registered for that scheme together with the scheme-stripped path. URIs
+// without a "scheme://" prefix are treated as "file" so existing relative
+// and absolute paths keep working unchanged.
+func (r *Registry) Resolve(uri string) (FileProcessor, string, error) {
+	scheme, rest := splitScheme(uri)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if processor, ok := r.cache[scheme]; ok {
+		return processor, rest, nil
+	}
+
+	factory, ok := r.factories[scheme]
+	if !ok {
+		return nil, "", fmt.Errorf("no file processor registered for scheme %q", scheme)
+	}
+
+	processor, err := factory()
+	if err != nil {
+		return nil, "", fmt.Errorf("building file processor for scheme %q: %w", scheme, err)
+	}
+
+	r.cache[scheme] = processor
+
+	return processor, rest, nil
+}
+
+func splitScheme(uri string) (scheme string, rest string) {
+	if idx := strings.Index(uri, "://"); idx != -1 {
+		return uri[:idx], uri[idx+3:]
+	}
+	return "file", uri
+}
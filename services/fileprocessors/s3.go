@@ -0,0 +1,342 @@
+package fileprocessors
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// S3FileProcessor implements the FileProcessor interface for the "s3://"
+// scheme. Paths are of the form "bucket/key/to/object"; credentials and
+// region are read from the standard AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY,
+// AWS_SESSION_TOKEN and AWS_REGION environment variables so no extra CLI
+// flags are required.
+type S3FileProcessor struct {
+	client          *http.Client
+	region          string
+	accessKeyID     string
+	secretAccessKey string
+	sessionToken    string
+	// now returns the request time used for signing. Defaults to time.Now in
+	// NewS3FileProcessor; overridden in tests so a signature can be checked
+	// against one computed independently for a fixed date.
+	now func() time.Time
+}
+
+// creates a new S3FileProcessor, reading credentials from the environment.
+func NewS3FileProcessor() (*S3FileProcessor, error) {
+	accessKeyID := os.Getenv("AWS_ACCESS_KEY_ID")
+	secretAccessKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
+
+	if accessKeyID == "" || secretAccessKey == "" {
+		return nil, fmt.Errorf("s3:// requires AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY to be set")
+	}
+
+	region := os.Getenv("AWS_REGION")
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	return &S3FileProcessor{
+		client:          &http.Client{Timeout: 30 * time.Second},
+		region:          region,
+		accessKeyID:     accessKeyID,
+		secretAccessKey: secretAccessKey,
+		sessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		now:             time.Now,
+	}, nil
+}
+
+func (s *S3FileProcessor) splitBucketKey(filePath string) (bucket string, key string) {
+	filePath = strings.TrimPrefix(filePath, "/")
+	parts := strings.SplitN(filePath, "/", 2)
+
+	if len(parts) == 1 {
+		return parts[0], ""
+	}
+
+	return parts[0], parts[1]
+}
+
+func (s *S3FileProcessor) endpoint(bucket string) string {
+	return fmt.Sprintf("https://%s.s3.%s.amazonaws.com", bucket, s.region)
+}
+
+// ReadFile downloads "bucket/key" from S3.
+func (s *S3FileProcessor) ReadFile(filePath string) ([]byte, error) {
+	bucket, key := s.splitBucketKey(filePath)
+
+	req, err := http.NewRequest(http.MethodGet, s.endpoint(bucket)+"/"+key, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.sign(req, nil); err != nil {
+		return nil, err
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("GET s3://%s: unexpected status %s: %s", filePath, resp.Status, body)
+	}
+
+	return io.ReadAll(resp.Body)
+}
+
+// WriteFile uploads data to "bucket/key" in S3.
+func (s *S3FileProcessor) WriteFile(filePath string, data []byte) error {
+	bucket, key := s.splitBucketKey(filePath)
+
+	req, err := http.NewRequest(http.MethodPut, s.endpoint(bucket)+"/"+key, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	if err := s.sign(req, data); err != nil {
+		return err
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("PUT s3://%s: unexpected status %s: %s", filePath, resp.Status, body)
+	}
+
+	return nil
+}
+
+type s3ListBucketResult struct {
+	Contents []struct {
+		Key string `xml:"Key"`
+	} `xml:"Contents"`
+}
+
+// FindFiles lists "bucket/prefix" and matches each full key against the
+// glob's full key pattern with path.Match, so "*" does not cross a "/"
+// (S3 has no native glob support).
+func (s *S3FileProcessor) FindFiles(globs []string) ([]string, error) {
+	var result []string
+
+	for _, glob := range globs {
+		bucket, prefixGlob := s.splitBucketKey(glob)
+		prefix := prefixGlob[:strings.IndexAny(prefixGlob+"*?[", "*?[")]
+
+		req, err := http.NewRequest(http.MethodGet, s.endpoint(bucket)+"/?list-type=2&prefix="+url.QueryEscape(prefix), nil)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := s.sign(req, nil); err != nil {
+			return nil, err
+		}
+
+		resp, err := s.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("LIST s3://%s: unexpected status %s: %s", glob, resp.Status, body)
+		}
+
+		var parsed s3ListBucketResult
+		if err := xml.Unmarshal(body, &parsed); err != nil {
+			return nil, err
+		}
+
+		for _, object := range parsed.Contents {
+			matched, err := path.Match(prefixGlob, object.Key)
+			if err != nil {
+				return nil, err
+			}
+			if matched {
+				result = append(result, bucket+"/"+object.Key)
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// get path to file
+func (s *S3FileProcessor) GetPathToFile(filename string) string {
+	return path.Dir(filename)
+}
+
+// Exists issues a HEAD request to check whether the object exists.
+func (s *S3FileProcessor) Exists(filePath string) (bool, error) {
+	_, err := s.Stat(filePath)
+	if err != nil {
+		return false, nil
+	}
+	return true, nil
+}
+
+// Stat issues a HEAD request for "bucket/key" and reports Content-Length.
+func (s *S3FileProcessor) Stat(filePath string) (FileInfo, error) {
+	bucket, key := s.splitBucketKey(filePath)
+
+	req, err := http.NewRequest(http.MethodHead, s.endpoint(bucket)+"/"+key, nil)
+	if err != nil {
+		return FileInfo{}, err
+	}
+
+	if err := s.sign(req, nil); err != nil {
+		return FileInfo{}, err
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return FileInfo{}, fmt.Errorf("HEAD s3://%s: unexpected status %s", filePath, resp.Status)
+	}
+
+	return FileInfo{Name: path.Base(key), Size: resp.ContentLength}, nil
+}
+
+// ReadDir lists the objects directly under "bucket/prefix" by globbing
+// "prefix/*" through FindFiles. Deeper keys are filtered out, so nested
+// "directories" do not appear as entries.
+func (s *S3FileProcessor) ReadDir(dirPath string) ([]string, error) {
+	names, err := s.FindFiles([]string{strings.TrimSuffix(dirPath, "/") + "/*"})
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]string, len(names))
+	for i, name := range names {
+		entries[i] = path.Base(name)
+	}
+	return entries, nil
+}
+
+// Realpath is a no-op for the s3 backend: "bucket/key" is already canonical.
+func (s *S3FileProcessor) Realpath(filePath string) (string, error) {
+	return filePath, nil
+}
+
+// sign applies AWS Signature Version 4 to req, as required for every S3 REST call.
+func (s *S3FileProcessor) sign(req *http.Request, body []byte) error {
+	nowFunc := s.now
+	if nowFunc == nil {
+		nowFunc = time.Now
+	}
+	now := nowFunc().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	dateStamp := now.Format("20060102")
+
+	payloadHash := hashPayload(body)
+
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+	if s.sessionToken != "" {
+		req.Header.Set("x-amz-security-token", s.sessionToken)
+	}
+	req.Header.Set("Host", req.URL.Host)
+
+	signedHeaders, canonicalHeaders := canonicalizeHeaders(req.Header)
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	credentialScope := fmt.Sprintf("%s/%s/s3/aws4_request", dateStamp, s.region)
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		credentialScope,
+		hashString(canonicalRequest),
+	}, "\n")
+
+	signingKey := deriveSigningKey(s.secretAccessKey, dateStamp, s.region, "s3")
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKeyID, credentialScope, signedHeaders, signature,
+	))
+
+	return nil
+}
+
+func canonicalizeHeaders(header http.Header) (signedHeaders string, canonicalHeaders string) {
+	names := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	if header.Get("x-amz-security-token") != "" {
+		names = append(names, "x-amz-security-token")
+	}
+
+	var canonical strings.Builder
+	for _, name := range names {
+		canonical.WriteString(name)
+		canonical.WriteString(":")
+		canonical.WriteString(strings.TrimSpace(header.Get(name)))
+		canonical.WriteString("\n")
+	}
+
+	return strings.Join(names, ";"), canonical.String()
+}
+
+func hashPayload(body []byte) string {
+	if body == nil {
+		body = []byte{}
+	}
+	return hashBytes(body)
+}
+
+func hashBytes(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hashString(data string) string {
+	return hashBytes([]byte(data))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func deriveSigningKey(secretAccessKey, dateStamp, region, service string) []byte {
+	kDate := hmacSHA256([]byte("AWS4"+secretAccessKey), dateStamp)
+	kRegion := hmacSHA256(kDate, region)
+	kService := hmacSHA256(kRegion, service)
+	return hmacSHA256(kService, "aws4_request")
+}
@@ -1,8 +1,27 @@
 package fileprocessors
 
+// FileInfo is a backend-agnostic subset of os.FileInfo, returned by Stat so
+// callers (e.g. the efemel.fs Lua module) never need to assume a local
+// filesystem is underneath.
+type FileInfo struct {
+	Name    string
+	Size    int64
+	IsDir   bool
+	ModTime int64 // unix seconds; 0 when the backend doesn't track it
+}
+
 type FileProcessor interface {
 	ReadFile(filePath string) ([]byte, error)
 	WriteFile(filePath string, data []byte) error
 	FindFiles(globs []string) ([]string, error)
 	GetPathToFile(filename string) string
+
+	// Exists reports whether filePath is present on this backend.
+	Exists(filePath string) (bool, error)
+	// Stat returns metadata about filePath.
+	Stat(filePath string) (FileInfo, error)
+	// ReadDir lists the immediate entries of dirPath.
+	ReadDir(dirPath string) ([]string, error)
+	// Realpath resolves filePath to this backend's canonical form.
+	Realpath(filePath string) (string, error)
 }
\ No newline at end of file
@@ -0,0 +1,222 @@
+package main
+
+import (
+	fileprocessors "efemel/services/fileprocessors"
+	"path"
+	"strings"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// LuaModule is a named module backed by Go, registered into a
+// LuaStateManager's package.preload table so scripts can
+// require("efemel.fs") / require("efemel.http") / require("efemel.path").
+type LuaModule struct {
+	Name   string
+	Loader lua.LGFunction
+}
+
+// fsResolver decides which backend a single efemel.fs call should use for
+// filePath, returning the processor together with the path that should
+// actually be passed to it (scheme stripped, when one was resolved via the
+// registry).
+type fsResolver func(filePath string) (fileprocessors.FileProcessor, string, error)
+
+// resolveFSTarget looks at filePath the same way a CLI input glob is
+// resolved: a path carrying an explicit "scheme://" prefix (http://, s3://,
+// mem://, ...) is resolved against registry, so a script's manifest can
+// reach a different backend than its own; a bare path keeps using fallback -
+// the job's own script backend (see BeginJob) - so existing relative reads
+// on a non-local script backend keep working unchanged.
+func resolveFSTarget(registry *fileprocessors.Registry, fallback func() fileprocessors.FileProcessor, filePath string) (fileprocessors.FileProcessor, string, error) {
+	if registry != nil && strings.Contains(filePath, "://") {
+		return registry.Resolve(filePath)
+	}
+	return fallback(), filePath, nil
+}
+
+// NewFSModule exposes efemel.fs to Lua scripts. Every call resolves its own
+// path fresh (see resolveFSTarget) rather than trusting a backend fixed at
+// construction: a bare path goes to whichever backend BeginJob set for the
+// current job, and a "scheme://" path to the backend the registry resolves,
+// so a pooled state shared across jobs always honors whichever VFS backend
+// (file://, mem://, s3://, ...) each individual call actually targets.
+func NewFSModule(manager *LuaStateManager, registry *fileprocessors.Registry) LuaModule {
+	resolve := func(filePath string) (fileprocessors.FileProcessor, string, error) {
+		return resolveFSTarget(registry, manager.FSProcessor, filePath)
+	}
+
+	return LuaModule{
+		Name: "efemel.fs",
+		Loader: func(L *lua.LState) int {
+			exports := L.NewTable()
+			L.SetFuncs(exports, map[string]lua.LGFunction{
+				"exists":   fsExists(resolve),
+				"read":     fsRead(manager, resolve),
+				"write":    fsWrite(resolve),
+				"glob":     fsGlob(resolve),
+				"readdir":  fsReaddir(resolve),
+				"stat":     fsStat(resolve),
+				"dirname":  fsDirname,
+				"basename": fsBasename,
+				"realpath": fsRealpath(resolve),
+			})
+			L.Push(exports)
+			return 1
+		},
+	}
+}
+
+func fsExists(resolve fsResolver) lua.LGFunction {
+	return func(L *lua.LState) int {
+		processor, filePath, err := resolve(L.CheckString(1))
+		if err != nil {
+			L.RaiseError("%s", err)
+			return 0
+		}
+
+		exists, err := processor.Exists(filePath)
+		if err != nil {
+			L.RaiseError("%s", err)
+			return 0
+		}
+		L.Push(lua.LBool(exists))
+		return 1
+	}
+}
+
+func fsRead(manager *LuaStateManager, resolve fsResolver) lua.LGFunction {
+	return func(L *lua.LState) int {
+		processor, filePath, err := resolve(L.CheckString(1))
+		if err != nil {
+			L.RaiseError("%s", err)
+			return 0
+		}
+
+		// Recorded against whichever backend this particular read actually
+		// used, not the job's own script backend - they can differ once a
+		// call carries its own "scheme://" prefix. See hashDependencies.
+		manager.RecordDependency(filePath, processor)
+
+		data, err := processor.ReadFile(filePath)
+		if err != nil {
+			L.RaiseError("%s", err)
+			return 0
+		}
+		L.Push(lua.LString(data))
+		return 1
+	}
+}
+
+func fsWrite(resolve fsResolver) lua.LGFunction {
+	return func(L *lua.LState) int {
+		processor, filePath, err := resolve(L.CheckString(1))
+		if err != nil {
+			L.RaiseError("%s", err)
+			return 0
+		}
+
+		if err := processor.WriteFile(filePath, []byte(L.CheckString(2))); err != nil {
+			L.RaiseError("%s", err)
+			return 0
+		}
+		return 0
+	}
+}
+
+func fsGlob(resolve fsResolver) lua.LGFunction {
+	return func(L *lua.LState) int {
+		processor, filePath, err := resolve(L.CheckString(1))
+		if err != nil {
+			L.RaiseError("%s", err)
+			return 0
+		}
+
+		matches, err := processor.FindFiles([]string{filePath})
+		if err != nil {
+			L.RaiseError("%s", err)
+			return 0
+		}
+		L.Push(stringsToLuaArray(L, matches))
+		return 1
+	}
+}
+
+func fsReaddir(resolve fsResolver) lua.LGFunction {
+	return func(L *lua.LState) int {
+		processor, filePath, err := resolve(L.CheckString(1))
+		if err != nil {
+			L.RaiseError("%s", err)
+			return 0
+		}
+
+		entries, err := processor.ReadDir(filePath)
+		if err != nil {
+			L.RaiseError("%s", err)
+			return 0
+		}
+		L.Push(stringsToLuaArray(L, entries))
+		return 1
+	}
+}
+
+func fsStat(resolve fsResolver) lua.LGFunction {
+	return func(L *lua.LState) int {
+		processor, filePath, err := resolve(L.CheckString(1))
+		if err != nil {
+			L.RaiseError("%s", err)
+			return 0
+		}
+
+		info, err := processor.Stat(filePath)
+		if err != nil {
+			L.RaiseError("%s", err)
+			return 0
+		}
+
+		result := L.NewTable()
+		result.RawSetString("name", lua.LString(info.Name))
+		result.RawSetString("size", lua.LNumber(info.Size))
+		result.RawSetString("is_dir", lua.LBool(info.IsDir))
+		result.RawSetString("mod_time", lua.LNumber(info.ModTime))
+
+		L.Push(result)
+		return 1
+	}
+}
+
+func fsRealpath(resolve fsResolver) lua.LGFunction {
+	return func(L *lua.LState) int {
+		processor, filePath, err := resolve(L.CheckString(1))
+		if err != nil {
+			L.RaiseError("%s", err)
+			return 0
+		}
+
+		realPath, err := processor.Realpath(filePath)
+		if err != nil {
+			L.RaiseError("%s", err)
+			return 0
+		}
+		L.Push(lua.LString(realPath))
+		return 1
+	}
+}
+
+func fsDirname(L *lua.LState) int {
+	L.Push(lua.LString(path.Dir(L.CheckString(1))))
+	return 1
+}
+
+func fsBasename(L *lua.LState) int {
+	L.Push(lua.LString(path.Base(L.CheckString(1))))
+	return 1
+}
+
+func stringsToLuaArray(L *lua.LState, values []string) *lua.LTable {
+	table := L.NewTable()
+	for _, value := range values {
+		table.Append(lua.LString(value))
+	}
+	return table
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// NewHTTPModule exposes efemel.http to Lua scripts: get/post/request, each
+// returning {status, headers, body} so manifests can fetch remote schemas
+// or template snippets without shelling out.
+func NewHTTPModule() LuaModule {
+	return LuaModule{
+		Name: "efemel.http",
+		Loader: func(L *lua.LState) int {
+			exports := L.NewTable()
+			L.SetFuncs(exports, map[string]lua.LGFunction{
+				"get":     httpVerb(http.MethodGet),
+				"post":    httpVerb(http.MethodPost),
+				"request": httpRequest,
+			})
+			L.Push(exports)
+			return 1
+		},
+	}
+}
+
+func httpVerb(method string) lua.LGFunction {
+	return func(L *lua.LState) int {
+		url := L.CheckString(1)
+
+		var body io.Reader
+		if L.GetTop() >= 2 {
+			body = bytes.NewBufferString(L.CheckString(2))
+		}
+
+		return doHTTPRequest(L, method, url, body)
+	}
+}
+
+func httpRequest(L *lua.LState) int {
+	options := L.CheckTable(1)
+
+	method := luaTableStringField(options, "method", http.MethodGet)
+	url := luaTableStringField(options, "url", "")
+
+	var body io.Reader
+	if requestBody := luaTableStringField(options, "body", ""); requestBody != "" {
+		body = bytes.NewBufferString(requestBody)
+	}
+
+	return doHTTPRequest(L, method, url, body)
+}
+
+func doHTTPRequest(L *lua.LState, method, url string, body io.Reader) int {
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		L.RaiseError("%s", err)
+		return 0
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		L.RaiseError("%s", err)
+		return 0
+	}
+	defer resp.Body.Close()
+
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		L.RaiseError("%s", err)
+		return 0
+	}
+
+	headers := L.NewTable()
+	for name, values := range resp.Header {
+		if len(values) > 0 {
+			headers.RawSetString(name, lua.LString(values[0]))
+		}
+	}
+
+	result := L.NewTable()
+	result.RawSetString("status", lua.LNumber(resp.StatusCode))
+	result.RawSetString("headers", headers)
+	result.RawSetString("body", lua.LString(responseBody))
+
+	L.Push(result)
+	return 1
+}
+
+func luaTableStringField(table *lua.LTable, key, defaultValue string) string {
+	value := table.RawGetString(key)
+	if value == lua.LNil {
+		return defaultValue
+	}
+	return value.String()
+}
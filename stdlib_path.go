@@ -0,0 +1,45 @@
+package main
+
+import (
+	"path"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// NewPathModule exposes efemel.path to Lua scripts: join, split, ext.
+func NewPathModule() LuaModule {
+	return LuaModule{
+		Name: "efemel.path",
+		Loader: func(L *lua.LState) int {
+			exports := L.NewTable()
+			L.SetFuncs(exports, map[string]lua.LGFunction{
+				"join":  pathJoin,
+				"split": pathSplit,
+				"ext":   pathExt,
+			})
+			L.Push(exports)
+			return 1
+		},
+	}
+}
+
+func pathJoin(L *lua.LState) int {
+	parts := make([]string, L.GetTop())
+	for i := 1; i <= L.GetTop(); i++ {
+		parts[i-1] = L.CheckString(i)
+	}
+	L.Push(lua.LString(path.Join(parts...)))
+	return 1
+}
+
+func pathSplit(L *lua.LState) int {
+	dir, file := path.Split(L.CheckString(1))
+	L.Push(lua.LString(dir))
+	L.Push(lua.LString(file))
+	return 2
+}
+
+func pathExt(L *lua.LState) int {
+	L.Push(lua.LString(path.Ext(L.CheckString(1))))
+	return 1
+}
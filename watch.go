@@ -0,0 +1,170 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"sync"
+
+	fileprocessors "efemel/services/fileprocessors"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// CacheEntry records what an output file was last built from: the original
+// input script (Filename), every file the job's dependency tracking saw
+// (the script itself, anything it required, anything read via efemel.fs),
+// and a content hash of those dependencies. --watch uses Deps to know what
+// to watch, and a fresh run uses Hash to skip rewriting unchanged output.
+type CacheEntry struct {
+	Filename string   `json:"filename"`
+	Deps     []string `json:"deps"`
+	Hash     string   `json:"hash"`
+}
+
+// Cache maps an output filename to the CacheEntry it was last built with,
+// persisted as .efemel-cache.json alongside the rendered output.
+type Cache map[string]CacheEntry
+
+const cacheFileName = ".efemel-cache.json"
+
+// cachePathFor returns where the cache file lives for a given --output-path.
+func cachePathFor(outputPath string) string {
+	return path.Join(outputPath, cacheFileName)
+}
+
+// loadCache reads a previous run's cache, if any. A missing or unreadable
+// cache just means every output looks new, which is always safe.
+func loadCache(processor fileprocessors.FileProcessor, cachePath string) Cache {
+	data, err := processor.ReadFile(cachePath)
+	if err != nil {
+		return Cache{}
+	}
+
+	var cache Cache
+	if err := json.Unmarshal(data, &cache); err != nil {
+		return Cache{}
+	}
+
+	return cache
+}
+
+func saveCache(processor fileprocessors.FileProcessor, cachePath string, cache Cache) error {
+	data, err := json.MarshalIndent(cache, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return processor.WriteFile(cachePath, data)
+}
+
+// hashDependencies hashes the contents of every dep, sorted so the result
+// doesn't depend on discovery order. Each Dependency already carries the
+// backend it was actually read through (see LuaStateManager.DependencySet) -
+// the job's own script carries the backend it was read from (see BeginJob),
+// every require()-resolved module is local, and an efemel.fs.read dep may
+// have resolved against any other registered backend - so every dep is read through the backend it actually came from
+// rather than assuming one backend for the whole job. Two jobs with the same
+// script and requires hash identically, which is what lets unchanged outputs
+// be skipped even on a full rerun.
+func hashDependencies(deps []Dependency) (string, error) {
+	sorted := append([]Dependency(nil), deps...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Path < sorted[j].Path })
+
+	hasher := sha256.New()
+	for _, dep := range sorted {
+		data, err := dep.Processor.ReadFile(dep.Path)
+		if err != nil {
+			return "", err
+		}
+		hasher.Write([]byte(dep.Path))
+		hasher.Write([]byte{0})
+		hasher.Write(data)
+		hasher.Write([]byte{0})
+	}
+
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// watchAndRebuild watches every dependency recorded in cache and, on change,
+// rebuilds just the original input files whose dependency set contains the
+// changed path. cache is shared with the run calls build triggers, so it
+// keeps growing as rebuilds touch new dependencies.
+func watchAndRebuild(files []ResolvedFile, cache Cache, cacheMutex *sync.Mutex, build func([]ResolvedFile)) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	byFilename := make(map[string]ResolvedFile, len(files))
+	for _, file := range files {
+		byFilename[file.Filename] = file
+	}
+
+	addWatches := func() {
+		cacheMutex.Lock()
+		defer cacheMutex.Unlock()
+		for _, entry := range cache {
+			for _, dep := range entry.Deps {
+				// Best effort: a dep on a backend fsnotify can't see (or
+				// already watched) just fails silently here.
+				_ = watcher.Add(dep)
+			}
+		}
+	}
+	addWatches()
+
+	fmt.Println("Watching for changes (Ctrl+C to stop)...")
+
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Remove|fsnotify.Rename) == 0 {
+				continue
+			}
+
+			affected := affectedFiles(cache, cacheMutex, byFilename, event.Name)
+			if len(affected) == 0 {
+				continue
+			}
+
+			fmt.Println("Change detected in", event.Name, "- rebuilding", len(affected), "file(s)")
+			build(affected)
+			addWatches()
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			fmt.Println("Watch error:", err)
+		}
+	}
+}
+
+// affectedFiles returns the original ResolvedFiles whose last recorded
+// dependency set contains changed.
+func affectedFiles(cache Cache, cacheMutex *sync.Mutex, byFilename map[string]ResolvedFile, changed string) []ResolvedFile {
+	cacheMutex.Lock()
+	defer cacheMutex.Unlock()
+
+	var affected []ResolvedFile
+	for _, entry := range cache {
+		for _, dep := range entry.Deps {
+			if dep != changed {
+				continue
+			}
+			if file, ok := byFilename[entry.Filename]; ok {
+				affected = append(affected, file)
+			}
+			break
+		}
+	}
+
+	return affected
+}